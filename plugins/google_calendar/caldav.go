@@ -0,0 +1,222 @@
+package google_calendar
+
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	ical "github.com/emersion/go-ical"
+)
+
+/* CalDAVSource/ICSSource let the plugin point at any RFC 4791 server
+ * (Nextcloud, Radicale, iCloud, ...) instead of Google Calendar.
+ *
+ * !prefs set --room <roomid> --plugin google_calendar --key backend --value caldav
+ * !prefs set --room <roomid> --plugin google_calendar --key calendar-url --value https://dav.example.com/cal/work/
+ *
+ * or, for a plain published .ics file with no CalDAV support:
+ * !prefs set --room <roomid> --plugin google_calendar --key backend --value ics-url
+ * !prefs set --room <roomid> --plugin google_calendar --key calendar-url --value https://example.com/work.ics
+ */
+
+// CalDAVSource fetches events from a RFC 4791 CalDAV collection via a
+// calendar-query REPORT request scoped to the requested window.
+type CalDAVSource struct {
+	URL string
+}
+
+// Events implements CalendarSource.
+func (s *CalDAVSource) Events(ctx context.Context, window Window) ([]CalEvent, error) {
+	if s.URL == "" {
+		return nil, fmt.Errorf("google_calendar: calendar-url is not set for the caldav backend")
+	}
+
+	body := calendarQueryBody(window)
+
+	req, err := http.NewRequest("REPORT", s.URL, strings.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("unable to build CalDAV REPORT request: %s", err)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+	req.Header.Set("Depth", "1")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("CalDAV REPORT request to %q failed: %s", s.URL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusMultiStatus && resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("CalDAV REPORT request to %q returned %s", s.URL, resp.Status)
+	}
+
+	var ms multistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, fmt.Errorf("unable to parse CalDAV multistatus response: %s", err)
+	}
+
+	var out []CalEvent
+	for _, r := range ms.Responses {
+		if r.Propstat.Prop.CalendarData == "" {
+			continue
+		}
+		evts, err := parseICS(strings.NewReader(r.Propstat.Prop.CalendarData), window)
+		if err != nil {
+			continue
+		}
+		out = append(out, evts...)
+	}
+
+	return out, nil
+}
+
+// multistatus is the minimal subset of RFC 4791's DAV:multistatus response
+// needed to pull calendar-data out of a calendar-query REPORT.
+type multistatus struct {
+	XMLName   xml.Name `xml:"DAV: multistatus"`
+	Responses []struct {
+		Propstat struct {
+			Prop struct {
+				CalendarData string `xml:"urn:ietf:params:xml:ns:caldav calendar-data"`
+			} `xml:"prop"`
+		} `xml:"propstat"`
+	} `xml:"response"`
+}
+
+// calendarQueryBody builds a calendar-query REPORT body restricted to
+// VEVENTs overlapping window.
+func calendarQueryBody(window Window) string {
+	return fmt.Sprintf(`<?xml version="1.0" encoding="utf-8" ?>
+<C:calendar-query xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <D:prop>
+    <C:calendar-data/>
+  </D:prop>
+  <C:filter>
+    <C:comp-filter name="VCALENDAR">
+      <C:comp-filter name="VEVENT">
+        <C:time-range start="%s" end="%s"/>
+      </C:comp-filter>
+    </C:comp-filter>
+  </C:filter>
+</C:calendar-query>`, icalTime(window.Start), icalTime(window.End))
+}
+
+func icalTime(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+// ICSSource fetches a single published .ics document over plain HTTP(S) and
+// filters its VEVENTs down to window. Used for feeds that don't support
+// CalDAV REPORT queries (e.g. a published iCloud/Google "secret address").
+type ICSSource struct {
+	URL string
+}
+
+// Events implements CalendarSource.
+func (s *ICSSource) Events(ctx context.Context, window Window) ([]CalEvent, error) {
+	if s.URL == "" {
+		return nil, fmt.Errorf("google_calendar: calendar-url is not set for the ics-url backend")
+	}
+
+	req, err := http.NewRequest("GET", s.URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to build ICS request: %s", err)
+	}
+	req = req.WithContext(ctx)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching ICS feed %q failed: %s", s.URL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching ICS feed %q returned %s", s.URL, resp.Status)
+	}
+
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, resp.Body); err != nil {
+		return nil, fmt.Errorf("unable to read ICS feed %q: %s", s.URL, err)
+	}
+
+	return parseICS(&buf, window)
+}
+
+// parseICS decodes an ICS document and returns the VEVENTs that overlap
+// window as CalEvents.
+func parseICS(r io.Reader, window Window) ([]CalEvent, error) {
+	dec := ical.NewDecoder(r)
+
+	var out []CalEvent
+
+	for {
+		cal, err := dec.Decode()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse ICS data: %s", err)
+		}
+
+		for _, comp := range cal.Children {
+			if comp.Name != ical.CompEvent {
+				continue
+			}
+
+			start, err := comp.Props.DateTime("DTSTART", time.UTC)
+			if err != nil {
+				continue
+			}
+			end, err := comp.Props.DateTime("DTEND", time.UTC)
+			if err != nil {
+				continue
+			}
+
+			if end.Before(window.Start) || start.After(window.End) {
+				continue
+			}
+
+			ce := CalEvent{Start: start, End: end}
+
+			if summary := comp.Props.Get("SUMMARY"); summary != nil {
+				ce.Name = summary.Value
+			}
+			if description := comp.Props.Get("DESCRIPTION"); description != nil {
+				ce.Description = description.Value
+			}
+			if rrule := comp.Props.Get("RRULE"); rrule != nil {
+				ce.RRule = rrule.Value
+			}
+			if rdate := comp.Props.Get("RDATE"); rdate != nil {
+				ce.RDates = parseRecurrenceDates(rdate.Value)
+			}
+			if exdate := comp.Props.Get("EXDATE"); exdate != nil {
+				ce.ExDates = parseRecurrenceDates(exdate.Value)
+			}
+
+			out = append(out, ce)
+		}
+	}
+
+	return out, nil
+}
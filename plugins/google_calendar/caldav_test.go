@@ -0,0 +1,95 @@
+package google_calendar
+
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseICSMissingDescription(t *testing.T) {
+	ics := "BEGIN:VCALENDAR\r\n" +
+		"VERSION:2.0\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"UID:1\r\n" +
+		"SUMMARY:Standup\r\n" +
+		"DTSTART:20260720T090000Z\r\n" +
+		"DTEND:20260720T093000Z\r\n" +
+		"END:VEVENT\r\n" +
+		"END:VCALENDAR\r\n"
+
+	window := Window{Start: time.Date(2026, 7, 20, 0, 0, 0, 0, time.UTC), End: time.Date(2026, 7, 21, 0, 0, 0, 0, time.UTC)}
+
+	evts, err := parseICS(strings.NewReader(ics), window)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(evts) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(evts))
+	}
+	if evts[0].Name != "Standup" {
+		t.Fatalf("expected name %q, got %q", "Standup", evts[0].Name)
+	}
+	if evts[0].Description != "" {
+		t.Fatalf("expected no description, got %q", evts[0].Description)
+	}
+}
+
+func TestParseICSMissingSummaryAndDescription(t *testing.T) {
+	ics := "BEGIN:VCALENDAR\r\n" +
+		"VERSION:2.0\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"UID:1\r\n" +
+		"DTSTART:20260720T090000Z\r\n" +
+		"DTEND:20260720T093000Z\r\n" +
+		"END:VEVENT\r\n" +
+		"END:VCALENDAR\r\n"
+
+	window := Window{Start: time.Date(2026, 7, 20, 0, 0, 0, 0, time.UTC), End: time.Date(2026, 7, 21, 0, 0, 0, 0, time.UTC)}
+
+	evts, err := parseICS(strings.NewReader(ics), window)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(evts) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(evts))
+	}
+	if evts[0].Name != "" || evts[0].Description != "" {
+		t.Fatalf("expected empty name/description, got %q/%q", evts[0].Name, evts[0].Description)
+	}
+}
+
+func TestParseICSOutsideWindowExcluded(t *testing.T) {
+	ics := "BEGIN:VCALENDAR\r\n" +
+		"VERSION:2.0\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"UID:1\r\n" +
+		"SUMMARY:Next week\r\n" +
+		"DTSTART:20260801T090000Z\r\n" +
+		"DTEND:20260801T093000Z\r\n" +
+		"END:VEVENT\r\n" +
+		"END:VCALENDAR\r\n"
+
+	window := Window{Start: time.Date(2026, 7, 20, 0, 0, 0, 0, time.UTC), End: time.Date(2026, 7, 21, 0, 0, 0, 0, time.UTC)}
+
+	evts, err := parseICS(strings.NewReader(ics), window)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(evts) != 0 {
+		t.Fatalf("expected the event outside the window to be excluded, got %d", len(evts))
+	}
+}
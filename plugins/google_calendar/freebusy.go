@@ -0,0 +1,300 @@
+package google_calendar
+
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/netflix/hal-9001/hal"
+	calendar "google.golang.org/api/calendar/v3"
+)
+
+/* Free/busy lookups and room finding, built on the Calendar FreeBusy API.
+ *
+ * !gcal freebusy <user-or-room> [duration]   -- next open slot for a calendar,
+ * defaulting to a 30 minute slot.
+ * !gcal findroom <duration> [capacity]       -- which configured resource
+ * calendars are free for <duration> starting now, optionally requiring at
+ * least <capacity> seats.
+ *
+ * resource-calendars: comma-separated calendar ids to consider for findroom.
+ * !prefs set --room <roomid> --plugin google_calendar --key resource-calendars --value room-a@resource.calendar.google.com,room-b@resource.calendar.google.com
+ *
+ * resource-capacities: optional, comma-separated seat counts lined up
+ * positionally with resource-calendars. Rooms with no known capacity sort
+ * last and are always considered to satisfy a capacity filter.
+ * !prefs set --room <roomid> --plugin google_calendar --key resource-capacities --value 4,10
+ */
+
+const defaultFreebusyDuration = 30 * time.Minute
+const freebusyLookahead = 7 * 24 * time.Hour
+
+// resource is a single bookable calendar known to findroom.
+type resource struct {
+	CalendarId string
+	Capacity   int
+}
+
+func handleFreebusyCommand(evt hal.Evt, args string) {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		evt.Reply("usage: !gcal freebusy <user-or-room> [duration]")
+		return
+	}
+
+	calendarId := fields[0]
+	dur := defaultFreebusyDuration
+	if len(fields) > 1 {
+		d, err := time.ParseDuration(fields[1])
+		if err != nil {
+			evt.Replyf("couldn't parse duration %q: %s", fields[1], err)
+			return
+		}
+		dur = d
+	}
+
+	now := time.Now()
+	config := getCachedConfig(evt.RoomId, now)
+
+	svc, err := freebusyService(evt.RoomId, config.CalendarUser)
+	if err != nil {
+		evt.Replyf("couldn't reach the Calendar API: %s", err)
+		return
+	}
+
+	slot, err := nextFreeSlot(svc, []string{calendarId}, now, dur, config.Timezone)
+	if err != nil {
+		evt.Replyf("couldn't look up free/busy for %q: %s", calendarId, err)
+		return
+	}
+
+	if slot == nil {
+		evt.Replyf("%s has no free %s slot in the next week", calendarId, dur)
+		return
+	}
+
+	evt.Replyf("%s is next free for %s starting %s", calendarId, dur, slot.In(&config.Timezone).Format(time.RFC1123))
+}
+
+func handleFindroomCommand(evt hal.Evt, args string) {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		evt.Reply("usage: !gcal findroom <duration> [capacity]")
+		return
+	}
+
+	dur, err := time.ParseDuration(fields[0])
+	if err != nil {
+		evt.Replyf("couldn't parse duration %q: %s", fields[0], err)
+		return
+	}
+
+	minCapacity := 0
+	if len(fields) > 1 {
+		capacity, err := strconv.Atoi(fields[1])
+		if err != nil {
+			evt.Replyf("couldn't parse capacity %q: %s", fields[1], err)
+			return
+		}
+		minCapacity = capacity
+	}
+
+	now := time.Now()
+	config := getCachedConfig(evt.RoomId, now)
+
+	resources := loadResources(evt.RoomId)
+	if len(resources) == 0 {
+		evt.Reply("no resource-calendars configured for this room")
+		return
+	}
+
+	svc, err := freebusyService(evt.RoomId, config.CalendarUser)
+	if err != nil {
+		evt.Replyf("couldn't reach the Calendar API: %s", err)
+		return
+	}
+
+	var free []resource
+	for _, r := range resources {
+		if minCapacity > 0 && r.Capacity > 0 && r.Capacity < minCapacity {
+			continue
+		}
+
+		busy, err := isBusy(svc, r.CalendarId, now, now.Add(dur))
+		if err != nil {
+			continue
+		}
+		if !busy {
+			free = append(free, r)
+		}
+	}
+
+	sortResources(free)
+
+	if len(free) == 0 {
+		evt.Replyf("no rooms free for %s starting now", dur)
+		return
+	}
+
+	names := make([]string, len(free))
+	for i, r := range free {
+		if r.Capacity > 0 {
+			names[i] = fmt.Sprintf("%s (%d)", r.CalendarId, r.Capacity)
+		} else {
+			names[i] = r.CalendarId
+		}
+	}
+
+	evt.Replyf("free for %s starting now: %s", dur, strings.Join(names, ", "))
+}
+
+// sortResources orders resources by capacity ascending, with unknown
+// capacity (0) sorting after every room with a known capacity, breaking ties
+// by calendar id for a stable result.
+func sortResources(resources []resource) {
+	sort.Slice(resources, func(i, j int) bool {
+		if (resources[i].Capacity == 0) != (resources[j].Capacity == 0) {
+			return resources[j].Capacity == 0
+		}
+		if resources[i].Capacity != resources[j].Capacity {
+			return resources[i].Capacity < resources[j].Capacity
+		}
+		return resources[i].CalendarId < resources[j].CalendarId
+	})
+}
+
+// freebusyService builds a Calendar API client authenticated as userId, which
+// must already have run "!gcal auth".
+func freebusyService(roomId, userId string) (*calendar.Service, error) {
+	cc, err := NewCalClient(context.Background(), roomId, userId)
+	if err != nil {
+		return nil, err
+	}
+	if cc.Token == nil {
+		return nil, fmt.Errorf("no Google Calendar credentials on file for this room/user; run '!gcal auth' first")
+	}
+
+	return calendar.New(cc.HTTPClient().Client)
+}
+
+// isBusy reports whether calendarId has any busy period overlapping [from, to).
+func isBusy(svc *calendar.Service, calendarId string, from, to time.Time) (bool, error) {
+	resp, err := svc.Freebusy.Query(&calendar.FreeBusyRequest{
+		TimeMin: from.Format(time.RFC3339),
+		TimeMax: to.Format(time.RFC3339),
+		Items:   []*calendar.FreeBusyRequestItem{{Id: calendarId}},
+	}).Do()
+	if err != nil {
+		return false, err
+	}
+
+	cal, ok := resp.Calendars[calendarId]
+	if !ok {
+		return false, fmt.Errorf("no free/busy data returned for %q", calendarId)
+	}
+
+	return len(cal.Busy) > 0, nil
+}
+
+// nextFreeSlot scans forward from now in dur-sized steps looking for the
+// first window, up to freebusyLookahead out, with no busy period on any of
+// calendarIds.
+func nextFreeSlot(svc *calendar.Service, calendarIds []string, now time.Time, dur time.Duration, tz time.Location) (*time.Time, error) {
+	items := make([]*calendar.FreeBusyRequestItem, len(calendarIds))
+	for i, id := range calendarIds {
+		items[i] = &calendar.FreeBusyRequestItem{Id: id}
+	}
+
+	end := now.Add(freebusyLookahead)
+
+	resp, err := svc.Freebusy.Query(&calendar.FreeBusyRequest{
+		TimeMin: now.Format(time.RFC3339),
+		TimeMax: end.Format(time.RFC3339),
+		Items:   items,
+	}).Do()
+	if err != nil {
+		return nil, err
+	}
+
+	var busy []*calendar.TimePeriod
+	for _, id := range calendarIds {
+		cal, ok := resp.Calendars[id]
+		if !ok {
+			continue
+		}
+		busy = append(busy, cal.Busy...)
+	}
+
+	candidate := now
+	for candidate.Add(dur).Before(end) || candidate.Add(dur).Equal(end) {
+		if !overlapsAny(candidate, candidate.Add(dur), busy) {
+			return &candidate, nil
+		}
+		candidate = candidate.Add(dur)
+	}
+
+	return nil, nil
+}
+
+func overlapsAny(start, end time.Time, periods []*calendar.TimePeriod) bool {
+	for _, p := range periods {
+		pStart, err := time.Parse(time.RFC3339, p.Start)
+		if err != nil {
+			continue
+		}
+		pEnd, err := time.Parse(time.RFC3339, p.End)
+		if err != nil {
+			continue
+		}
+		if start.Before(pEnd) && end.After(pStart) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadResources reads the resource-calendars/resource-capacities prefs into
+// a list of resources findroom can search over.
+func loadResources(roomId string) []resource {
+	calPref := hal.GetPref("", "", roomId, "google_calendar", "resource-calendars", "")
+	if !calPref.Success || calPref.Value == "" {
+		return nil
+	}
+	ids := strings.Split(calPref.Value, ",")
+
+	capPref := hal.GetPref("", "", roomId, "google_calendar", "resource-capacities", "")
+	var capacities []string
+	if capPref.Success && capPref.Value != "" {
+		capacities = strings.Split(capPref.Value, ",")
+	}
+
+	out := make([]resource, len(ids))
+	for i, id := range ids {
+		r := resource{CalendarId: strings.TrimSpace(id)}
+		if i < len(capacities) {
+			if capacity, err := strconv.Atoi(strings.TrimSpace(capacities[i])); err == nil {
+				r.Capacity = capacity
+			}
+		}
+		out[i] = r
+	}
+
+	return out
+}
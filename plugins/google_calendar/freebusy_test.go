@@ -0,0 +1,79 @@
+package google_calendar
+
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"testing"
+	"time"
+
+	calendar "google.golang.org/api/calendar/v3"
+)
+
+func TestSortResourcesUnknownCapacitySortsLast(t *testing.T) {
+	resources := []resource{
+		{CalendarId: "room-c", Capacity: 0},
+		{CalendarId: "room-a", Capacity: 10},
+		{CalendarId: "room-b", Capacity: 4},
+	}
+
+	sortResources(resources)
+
+	want := []string{"room-b", "room-a", "room-c"}
+	for i, r := range resources {
+		if r.CalendarId != want[i] {
+			t.Fatalf("position %d: expected %q, got %q (%+v)", i, want[i], r.CalendarId, resources)
+		}
+	}
+}
+
+func TestSortResourcesTiesBreakByCalendarId(t *testing.T) {
+	resources := []resource{
+		{CalendarId: "room-z", Capacity: 4},
+		{CalendarId: "room-a", Capacity: 4},
+	}
+
+	sortResources(resources)
+
+	if resources[0].CalendarId != "room-a" || resources[1].CalendarId != "room-z" {
+		t.Fatalf("expected a tie on capacity to break by calendar id, got %+v", resources)
+	}
+}
+
+func TestOverlapsAny(t *testing.T) {
+	busy := []*calendar.TimePeriod{
+		{Start: "2026-07-20T09:00:00Z", End: "2026-07-20T10:00:00Z"},
+	}
+
+	overlapping := time.Date(2026, 7, 20, 9, 30, 0, 0, time.UTC)
+	if !overlapsAny(overlapping, overlapping.Add(time.Hour), busy) {
+		t.Fatal("expected a window overlapping the busy period to report true")
+	}
+
+	free := time.Date(2026, 7, 20, 11, 0, 0, 0, time.UTC)
+	if overlapsAny(free, free.Add(time.Hour), busy) {
+		t.Fatal("expected a window after the busy period to report false")
+	}
+}
+
+func TestOverlapsAnySkipsUnparseablePeriods(t *testing.T) {
+	busy := []*calendar.TimePeriod{
+		{Start: "not-a-time", End: "also-not-a-time"},
+	}
+
+	start := time.Date(2026, 7, 20, 9, 0, 0, 0, time.UTC)
+	if overlapsAny(start, start.Add(time.Hour), busy) {
+		t.Fatal("expected an unparseable busy period to be skipped, not treated as an overlap")
+	}
+}
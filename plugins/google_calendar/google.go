@@ -0,0 +1,188 @@
+package google_calendar
+
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	calendar "google.golang.org/api/calendar/v3"
+	"golang.org/x/oauth2"
+)
+
+// CalEvent is a backend-agnostic representation of a single calendar event.
+// RRule/RDates/ExDates describe recurrence and are expanded into concrete
+// instances by Occurrences. Start/End on an RRule event describe its first
+// instance; an event with RDates but no RRule yields only its RDates as
+// instances, and Start/End then only give the instance duration.
+type CalEvent struct {
+	Id          string
+	Name        string
+	Description string
+	Start       time.Time
+	End         time.Time
+	RRule       string
+	RDates      []time.Time
+	ExDates     []time.Time
+	Cancelled   bool
+}
+
+// httpClientWrapper holds the oauth2-backed *http.Client returned by
+// CalClient.HTTPClient(); callers pass its embedded Client to calendar.New.
+type httpClientWrapper struct {
+	*http.Client
+}
+
+// tokenFromJSON/tokenToJSON (de)serialize an oauth2.Token for storage in
+// hal.Secrets, which only deals in strings.
+func tokenFromJSON(s string) (*oauth2.Token, error) {
+	tok := &oauth2.Token{}
+	if err := json.Unmarshal([]byte(s), tok); err != nil {
+		return nil, err
+	}
+	return tok, nil
+}
+
+func tokenToJSON(tok *oauth2.Token) (string, error) {
+	b, err := json.Marshal(tok)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// GoogleSource is the CalendarSource backed by the Google Calendar API,
+// authenticating as UserId's stored OAuth2 token for RoomId.
+type GoogleSource struct {
+	RoomId     string
+	UserId     string
+	CalendarId string
+}
+
+// Events implements CalendarSource, fetching a fresh window of events.
+func (g *GoogleSource) Events(ctx context.Context, window Window) ([]CalEvent, error) {
+	evts, _, err := g.EventsSync(ctx, window, "")
+	return evts, err
+}
+
+// EventsSync fetches events for window. When syncToken is empty this is a
+// full fetch of the window, same as Events. When syncToken is non-empty it
+// instead fetches only what changed since that token was issued, ignoring
+// window, per the Google Calendar incremental sync API; any event with
+// Cancelled set has been deleted since the last sync. The returned token
+// should be passed to the next call to continue the sync.
+func (g *GoogleSource) EventsSync(ctx context.Context, window Window, syncToken string) ([]CalEvent, string, error) {
+	cc, err := NewCalClient(ctx, g.RoomId, g.UserId)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if cc.Token == nil {
+		return nil, "", fmt.Errorf("no Google Calendar credentials on file for this room/user; run '!gcal auth' first")
+	}
+
+	svc, err := calendar.New(cc.HTTPClient().Client)
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to create Google Calendar client: %s", err)
+	}
+
+	call := svc.Events.List(g.CalendarId).SingleEvents(true)
+	if syncToken != "" {
+		call = call.SyncToken(syncToken)
+	} else {
+		call = call.TimeMin(window.Start.Format(time.RFC3339)).
+			TimeMax(window.End.Format(time.RFC3339)).
+			OrderBy("startTime")
+	}
+
+	resp, err := call.Do()
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to fetch events for calendar %q: %s", g.CalendarId, err)
+	}
+
+	out := make([]CalEvent, 0, len(resp.Items))
+	for _, item := range resp.Items {
+		if item.Status == "cancelled" {
+			out = append(out, CalEvent{Id: item.Id, Cancelled: true})
+			continue
+		}
+
+		start, err := parseEventTime(item.Start.DateTime, item.Start.Date)
+		if err != nil {
+			continue
+		}
+		end, err := parseEventTime(item.End.DateTime, item.End.Date)
+		if err != nil {
+			continue
+		}
+
+		ce := CalEvent{
+			Id:          item.Id,
+			Name:        item.Summary,
+			Description: item.Description,
+			Start:       start,
+			End:         end,
+		}
+		parseGoogleRecurrence(&ce, item.Recurrence)
+
+		out = append(out, ce)
+	}
+
+	return out, resp.NextSyncToken, nil
+}
+
+// parseGoogleRecurrence fills in RRule/RDates/ExDates from the RRULE/RDATE/
+// EXDATE lines Google returns in Event.Recurrence.
+func parseGoogleRecurrence(ce *CalEvent, lines []string) {
+	for _, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "RRULE:"):
+			ce.RRule = strings.TrimPrefix(line, "RRULE:")
+		case strings.HasPrefix(line, "RDATE:"):
+			ce.RDates = append(ce.RDates, parseRecurrenceDates(strings.TrimPrefix(line, "RDATE:"))...)
+		case strings.HasPrefix(line, "EXDATE:"):
+			ce.ExDates = append(ce.ExDates, parseRecurrenceDates(strings.TrimPrefix(line, "EXDATE:"))...)
+		}
+	}
+}
+
+// parseRecurrenceDates parses a comma-separated list of iCalendar basic/
+// extended date-times, skipping any entry that doesn't parse.
+func parseRecurrenceDates(csv string) []time.Time {
+	var out []time.Time
+	for _, v := range strings.Split(csv, ",") {
+		if t, err := time.Parse("20060102T150405Z", v); err == nil {
+			out = append(out, t)
+			continue
+		}
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// parseEventTime handles the two shapes Google returns event times in:
+// RFC3339 for timed events and YYYY-MM-DD for all-day events.
+func parseEventTime(dateTime, date string) (time.Time, error) {
+	if dateTime != "" {
+		return time.Parse(time.RFC3339, dateTime)
+	}
+	return time.Parse("2006-01-02", date)
+}
@@ -0,0 +1,260 @@
+package google_calendar
+
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/netflix/hal-9001/hal"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	calendar "google.golang.org/api/calendar/v3"
+)
+
+/* OAuth2 wiring for the Google Calendar backend.
+ *
+ * Setup: store the contents of client_secret.json in hal.Secrets under the
+ * key google_calendar.client-secret.json (secretKeyClientJSON below).
+ *
+ * Per-user auth (required for the google backend: every Calendar API call
+ * runs as the room's calendar-user with that user's stored token, public
+ * calendars included). Google retired the old "copy this
+ * code back to the bot" out-of-band flow, so this requires webhook-base-url
+ * (see watch.go) to be configured for the room so Google has somewhere to
+ * redirect the user's browser back to:
+ * !gcal auth
+ * The bot will DM a consent URL; visiting and approving it completes the
+ * exchange directly against oauthCallbackPath, with no code to relay by hand.
+ */
+
+// oauthCallbackPath is the redirect_uri Google sends the user's browser back
+// to after they approve (or deny) access, completing the exchange started by
+// handleAuthCommand. Registered on hal's router in registerOAuthCallback.
+const oauthCallbackPath = "/google_calendar/oauth/callback"
+
+// pendingAuthTTL bounds how long a consent URL from "!gcal auth" stays valid.
+const pendingAuthTTL = 10 * time.Minute
+
+// pendingAuthEntry records who requested an auth flow, keyed by a random
+// per-request nonce so handleOAuthCallback can recover the right room/user to
+// bind the resulting token to instead of trusting anything in the callback
+// request itself.
+type pendingAuthEntry struct {
+	RoomId  string
+	UserId  string
+	Expires time.Time
+}
+
+var pendingAuth map[string]*pendingAuthEntry
+var pendingAuthMut sync.Mutex
+
+// newPendingAuth records a pending auth request under a fresh nonce, suitable
+// for passing as the OAuth2 "state" parameter.
+func newPendingAuth(roomId, userId string) (string, error) {
+	nonce, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	pendingAuthMut.Lock()
+	if pendingAuth == nil {
+		pendingAuth = make(map[string]*pendingAuthEntry)
+	}
+	pendingAuth[nonce] = &pendingAuthEntry{RoomId: roomId, UserId: userId, Expires: time.Now().Add(pendingAuthTTL)}
+	pendingAuthMut.Unlock()
+
+	return nonce, nil
+}
+
+// takePendingAuth consumes (one-shot) the pending auth entry for nonce,
+// returning nil if it doesn't exist or has expired.
+func takePendingAuth(nonce string) *pendingAuthEntry {
+	pendingAuthMut.Lock()
+	defer pendingAuthMut.Unlock()
+
+	e, ok := pendingAuth[nonce]
+	if !ok {
+		return nil
+	}
+	delete(pendingAuth, nonce)
+
+	if time.Now().After(e.Expires) {
+		return nil
+	}
+
+	return e
+}
+
+// secretKeyClientJSON is the global (per-Hal-instance) secrets key under which
+// the OAuth2 client-secret JSON downloaded from the Google API console is stored.
+const secretKeyClientJSON = "google_calendar.client-secret.json"
+
+// tokenSecretKey returns the per-room/per-user key under which a refresh token
+// is stored, allowing multiple Google accounts to be used across rooms.
+func tokenSecretKey(roomId, userId string) string {
+	return fmt.Sprintf("google_calendar.token.%s.%s", roomId, userId)
+}
+
+// CalClient wraps the pieces required to make authenticated calls against the
+// Google Calendar API on behalf of a given room/user pair.
+type CalClient struct {
+	ctx    context.Context
+	Config *oauth2.Config
+	Token  *oauth2.Token
+}
+
+// NewCalClient loads the client-secret JSON from hal.Secrets and the stored
+// refresh token for roomId/userId, returning a client ready to make requests.
+// If no token has been stored yet, Token is nil and the caller should direct
+// the user through AuthURL/Exchange before making API calls.
+func NewCalClient(ctx context.Context, roomId, userId string) (*CalClient, error) {
+	clientJSON, exists := hal.Secrets.Get(secretKeyClientJSON)
+	if !exists {
+		return nil, fmt.Errorf("google_calendar: no client-secret JSON has been configured (secret key %q)", secretKeyClientJSON)
+	}
+
+	conf, err := google.ConfigFromJSON([]byte(clientJSON), calendar.CalendarScope)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse client-secret JSON: %s", err)
+	}
+
+	cc := &CalClient{ctx: ctx, Config: conf}
+
+	if tokJSON, exists := hal.Secrets.Get(tokenSecretKey(roomId, userId)); exists {
+		tok, err := tokenFromJSON(tokJSON)
+		if err != nil {
+			return nil, fmt.Errorf("stored token for room %q user %q is corrupt: %s", roomId, userId, err)
+		}
+		cc.Token = tok
+	}
+
+	return cc, nil
+}
+
+// AuthURL returns the URL the user should visit to grant Hal access to their
+// calendar. state is echoed back verbatim on the redirect to oauthCallbackPath
+// and must be a value only the party who started this flow could know, so the
+// callback can trust which room/user to bind the resulting token to.
+func (cc *CalClient) AuthURL(state string) string {
+	return cc.Config.AuthCodeURL(state, oauth2.AccessTypeOffline)
+}
+
+// Exchange trades an authorization code returned by the consent screen for a
+// token and persists it under roomId/userId so future requests don't need to
+// re-authenticate.
+func (cc *CalClient) Exchange(roomId, userId, code string) error {
+	tok, err := cc.Config.Exchange(cc.ctx, code)
+	if err != nil {
+		return fmt.Errorf("unable to exchange authorization code for a token: %s", err)
+	}
+
+	cc.Token = tok
+
+	tokJSON, err := tokenToJSON(tok)
+	if err != nil {
+		return fmt.Errorf("unable to serialize token: %s", err)
+	}
+
+	hal.Secrets.Set(tokenSecretKey(roomId, userId), tokJSON)
+
+	return nil
+}
+
+// HTTPClient returns an *http.Client that automatically attaches/refreshes
+// the OAuth2 token, suitable for use with the Google Calendar API client.
+func (cc *CalClient) HTTPClient() *httpClientWrapper {
+	return &httpClientWrapper{cc.Config.Client(cc.ctx, cc.Token)}
+}
+
+// handleAuthCommand implements the "!gcal auth" chat command, DMing the user
+// a consent URL that completes the exchange directly against
+// oauthCallbackPath when they approve it; there's no code to relay back
+// through chat, so there's nothing for another room member to intercept.
+func handleAuthCommand(evt hal.Evt) {
+	config := getCachedConfig(evt.RoomId, time.Now())
+	if config.WebhookBaseURL == "" {
+		evt.Reply("Google Calendar auth needs webhook-base-url configured for this room so Google has somewhere to send the user back to; see watch.go's docs for how to set it.")
+		return
+	}
+
+	cc, err := NewCalClient(context.Background(), evt.RoomId, evt.User.Id)
+	if err != nil {
+		evt.Replyf("Unable to start auth flow: %s", err)
+		return
+	}
+	cc.Config.RedirectURL = config.WebhookBaseURL + oauthCallbackPath
+
+	if cc.Token != nil {
+		evt.Reply("You're already authenticated with Google Calendar; sending a new consent URL to re-authenticate.")
+	}
+
+	nonce, err := newPendingAuth(evt.RoomId, evt.User.Id)
+	if err != nil {
+		evt.Replyf("Unable to start auth flow: %s", err)
+		return
+	}
+
+	evt.User.Reply(fmt.Sprintf("To grant Hal access to your Google Calendar, visit this URL and approve access:\n%s", cc.AuthURL(nonce)))
+	evt.Reply("I've sent you a DM with instructions to authenticate with Google Calendar.")
+}
+
+// registerOAuthCallback wires the consent-flow redirect into hal's router.
+// Called once from Register, not per-room.
+func registerOAuthCallback() {
+	hal.Router.HandleFunc(oauthCallbackPath, handleOAuthCallback)
+}
+
+// handleOAuthCallback is the redirect_uri Google sends the user's browser
+// back to after consent. The room/user to bind the resulting token to comes
+// from the pending auth entry recorded for state, not from anything in the
+// request itself, so the exchange can't be hijacked by whoever's browser
+// happens to load this URL.
+func handleOAuthCallback(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	entry := takePendingAuth(q.Get("state"))
+	if entry == nil {
+		http.Error(w, "auth request expired or not recognized; run !gcal auth again", http.StatusBadRequest)
+		return
+	}
+
+	if errParam := q.Get("error"); errParam != "" {
+		hal.SendMessage(entry.RoomId, fmt.Sprintf("Google Calendar auth was not completed: %s", errParam))
+		http.Error(w, "auth was not completed", http.StatusOK)
+		return
+	}
+
+	config := getCachedConfig(entry.RoomId, time.Now())
+
+	cc, err := NewCalClient(context.Background(), entry.RoomId, entry.UserId)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("unable to complete auth: %s", err), http.StatusInternalServerError)
+		return
+	}
+	cc.Config.RedirectURL = config.WebhookBaseURL + oauthCallbackPath
+
+	if err := cc.Exchange(entry.RoomId, entry.UserId, q.Get("code")); err != nil {
+		hal.SendMessage(entry.RoomId, fmt.Sprintf("Google Calendar auth failed: %s", err))
+		http.Error(w, "auth failed", http.StatusInternalServerError)
+		return
+	}
+
+	hal.SendMessage(entry.RoomId, "Thanks, you're authenticated with Google Calendar now.")
+	fmt.Fprint(w, "You're authenticated with Google Calendar now; you can close this tab.")
+}
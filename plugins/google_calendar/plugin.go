@@ -17,9 +17,11 @@ package google_calendar
  */
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -37,6 +39,9 @@ import (
  * set, that will be the text sent to the room. Otherwise a default message is generated.
  * !prefs set --room <roomid> --plugin google_calendar --key autoreply --value true
  *
+ * autoreply-mode, autoreply-cooldown: how autoreply squelches repeats for
+ * the same event instance; see state.go.
+ *
  * announce-(start|end): the bot will automatically announce when an event is starting or
  * ending. The event's description will be included if it is not empty.
  * !prefs set --room <roomid> --plugin google_calendar --key announce-start --value true
@@ -44,23 +49,45 @@ import (
  *
  * timezone: optional, tells the bot which timezone to report dates in
  * !prefs set --room <roomid> --plugin google_calendar --key timezone --value America/Los_Angeles
+ *
+ * calendar-user, backend, calendar-url: which calendar source to read from
+ * and whose OAuth2 credentials to use; see source.go and google.go.
+ *
+ * Authenticating a user with Google Calendar: see oauth.go.
+ * !gcal auth          -- DMs you a consent URL
+ *
+ * webhook-base-url, announce-lead-minutes: background refresh, push
+ * notifications, and announce timing; see watch.go.
+ *
+ * Free/busy and room finding: !gcal freebusy/!gcal findroom; see freebusy.go.
  */
 
 const DefaultTz = "America/Los_Angeles"
 const DefaultMsg = "Calendar event: %q"
 
 type Config struct {
-	RoomId        string
-	CalendarId    string
-	Timezone      time.Location
-	Autoreply     bool
-	AnnounceStart bool
-	AnnounceEnd   bool
-	CalEvents     []CalEvent
-	LastReply     time.Time
-	mut           sync.Mutex
-	configTs      time.Time
-	calTs         time.Time
+	RoomId            string
+	CalendarId        string
+	CalendarUser      string
+	CalendarURL       string
+	Backend           string
+	WebhookBaseURL    string
+	Timezone          time.Location
+	Autoreply         bool
+	AnnounceStart     bool
+	AnnounceEnd       bool
+	AnnounceLeadMinutes int
+	AutoreplyMode     AutoreplyMode
+	AutoreplyCooldown time.Duration
+	CalEvents         []CalEvent
+	mut               sync.Mutex
+	configTs          time.Time
+	calTs             time.Time
+	rawEvents         []CalEvent
+	SyncToken         string
+	WatchChannelId    string
+	WatchResourceId   string
+	WatchToken        string
 }
 
 var configCache map[string]*Config
@@ -78,9 +105,13 @@ func Register() {
 	}
 
 	p.Register()
+
+	registerWatchEndpoint()
+	registerOAuthCallback()
 }
 
-// initData primes the cache and starts the background goroutine
+// initData primes the cache and starts the per-room background goroutines
+// that keep it fresh and fire start/end announcements.
 func initData(inst *hal.Instance) {
 	topMut.Lock()
 	config := Config{RoomId: inst.RoomId}
@@ -90,13 +121,18 @@ func initData(inst *hal.Instance) {
 	// initiate the loading of events
 	config.getCachedCalEvents(time.Now())
 
-	// TODO: kick off background refresh
+	go config.refreshLoop()
+	go config.announceLoop()
 }
 
 // handleEvt handles events coming in from the chat system. It does not interact
 // directly with the calendar API and relies on the background goroutine to populate
 // the cache.
 func handleEvt(evt hal.Evt) {
+	if handled := handleGcalCommand(evt); handled {
+		return
+	}
+
 	now := time.Now()
 	config := getCachedConfig(evt.RoomId, now)
 	calEvents, err := config.getCachedCalEvents(now)
@@ -106,44 +142,102 @@ func handleEvt(evt hal.Evt) {
 	}
 
 	for _, e := range calEvents {
-		if config.Autoreply && e.Start.Before(now) && e.End.After(now) {
-			lastReplyAge := now.Sub(config.LastReply)
-			// TODO: track more detailed state to make squelching replies easier
-			// for now: only reply once an hour
-			if lastReplyAge.Hours() < 1 {
-				log.Printf("not autoresponding because a message has been sent in the last hour")
-				continue
-			}
-
-			if e.Description != "" {
-				evt.Reply(e.Description)
-			} else {
-				evt.Replyf(DefaultMsg, e.Name)
-			}
-
-			config.LastReply = now
-			// return // TODO: should overlapping events mean multiple messages?
+		if !config.Autoreply || !e.Start.Before(now) || !e.End.After(now) {
+			continue
+		}
+
+		state := loadReplyState(config.RoomId, eventKey(e))
+		if !state.ShouldAutoreply(config.AutoreplyMode, config.AutoreplyCooldown, evt.User.Id, now) {
+			log.Printf("not autoresponding in room %q: squelched by autoreply-mode %q", config.RoomId, config.AutoreplyMode)
+			continue
+		}
+
+		if e.Description != "" {
+			evt.Reply(e.Description)
+		} else {
+			evt.Replyf(DefaultMsg, e.Name)
 		}
+
+		state.Save()
 	}
 }
 
-// TODO: announce start / end
+// handleGcalCommand looks for "!gcal ..." commands and dispatches them,
+// returning true if the event was a command handled here so the caller can
+// skip the normal autoreply logic.
+func handleGcalCommand(evt hal.Evt) bool {
+	body := strings.TrimSpace(evt.Body)
+
+	switch {
+	case body == "!gcal auth":
+		handleAuthCommand(evt)
+		return true
+	case strings.HasPrefix(body, "!gcal freebusy "):
+		handleFreebusyCommand(evt, strings.TrimPrefix(body, "!gcal freebusy "))
+		return true
+	case strings.HasPrefix(body, "!gcal findroom "):
+		handleFindroomCommand(evt, strings.TrimPrefix(body, "!gcal findroom "))
+		return true
+	}
+
+	return false
+}
 
 func getCachedConfig(roomId string, now time.Time) Config {
 	topMut.Lock()
 	c := configCache[roomId]
 	topMut.Unlock()
 
-	age := now.Sub(c.configTs)
-
-	if age.Minutes() > 10 {
+	if c.configAge(now).Minutes() > 10 {
 		c.LoadFromPrefs()
 	}
 
-	return *c
+	return c.snapshot()
+}
+
+// configAge reads configTs under c.mut so callers deciding whether to reload
+// don't race with LoadFromPrefs writing it from another goroutine.
+func (c *Config) configAge(now time.Time) time.Duration {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	return now.Sub(c.configTs)
 }
 
-// getCachedEvents fetches the calendar data from the Google Calendar API,
+// snapshot returns a copy of c's fields safe for a caller to read without
+// holding c.mut. It copies field-by-field rather than dereferencing c so it
+// never copies c.mut itself, which go vet (and the race detector, since
+// several fields here are also written from refreshLoop/announceLoop/
+// registerWatch) would otherwise flag.
+func (c *Config) snapshot() Config {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	return Config{
+		RoomId:              c.RoomId,
+		CalendarId:          c.CalendarId,
+		CalendarUser:        c.CalendarUser,
+		CalendarURL:         c.CalendarURL,
+		Backend:             c.Backend,
+		WebhookBaseURL:      c.WebhookBaseURL,
+		Timezone:            c.Timezone,
+		Autoreply:           c.Autoreply,
+		AnnounceStart:       c.AnnounceStart,
+		AnnounceEnd:         c.AnnounceEnd,
+		AnnounceLeadMinutes: c.AnnounceLeadMinutes,
+		AutoreplyMode:       c.AutoreplyMode,
+		AutoreplyCooldown:   c.AutoreplyCooldown,
+		CalEvents:           c.CalEvents,
+		configTs:            c.configTs,
+		calTs:               c.calTs,
+		rawEvents:           c.rawEvents,
+		SyncToken:           c.SyncToken,
+		WatchChannelId:      c.WatchChannelId,
+		WatchResourceId:     c.WatchResourceId,
+		WatchToken:          c.WatchToken,
+	}
+}
+
+// getCachedCalEvents fetches the calendar data from the configured backend,
 // holding a mutex while doing so. This prevents handleEvt from firing until
 // the first load of data is complete and will block the goroutines for a short
 // time.
@@ -154,11 +248,20 @@ func (c *Config) getCachedCalEvents(now time.Time) ([]CalEvent, error) {
 	calAge := now.Sub(c.calTs)
 
 	if calAge.Hours() > 1.5 {
-		evts, err := getEvents(c.CalendarId, now)
+		src, err := sourceFor(c)
+		if err != nil {
+			return nil, err
+		}
+
+		window := Window{Start: now.Add(-1 * time.Hour), End: now.Add(24 * time.Hour)}
+
+		evts, err := src.Events(context.Background(), window)
 		if err != nil {
 			return nil, err
 		} else {
-			c.CalEvents = evts
+			c.rawEvents = evts
+			c.CalEvents = expandOccurrences(evts, window)
+			c.calTs = now
 		}
 	}
 
@@ -169,12 +272,48 @@ func (c *Config) LoadFromPrefs() error {
 	c.mut.Lock()
 	defer c.mut.Unlock()
 
-	cidpref := hal.GetPref("", "", c.RoomId, "google_calendar", "calendar-id", "")
-	if cidpref.Success {
-		c.CalendarId = cidpref.Value
+	backendpref := hal.GetPref("", "", c.RoomId, "google_calendar", "backend", "google")
+	c.Backend = backendpref.Value
+
+	if c.Backend == "google" {
+		cidpref := hal.GetPref("", "", c.RoomId, "google_calendar", "calendar-id", "")
+		if cidpref.Success {
+			c.CalendarId = cidpref.Value
+		} else {
+			return fmt.Errorf("Failed to load calendar-id preference for room %q: %s", c.RoomId, cidpref.Error)
+		}
 	} else {
-		return fmt.Errorf("Failed to load calendar-id preference for room %q: %s", c.RoomId, cidpref.Error)
+		urlpref := hal.GetPref("", "", c.RoomId, "google_calendar", "calendar-url", "")
+		if !urlpref.Success {
+			return fmt.Errorf("Failed to load calendar-url preference for room %q: %s", c.RoomId, urlpref.Error)
+		}
+		c.CalendarURL = urlpref.Value
+	}
+
+	userpref := hal.GetPref("", "", c.RoomId, "google_calendar", "calendar-user", "")
+	c.CalendarUser = userpref.Value
+
+	webhookpref := hal.GetPref("", "", c.RoomId, "google_calendar", "webhook-base-url", "")
+	c.WebhookBaseURL = webhookpref.Value
+
+	leadpref := hal.GetPref("", "", c.RoomId, "google_calendar", "announce-lead-minutes", "5")
+	lead, err := strconv.Atoi(leadpref.Value)
+	if err != nil {
+		log.Printf("unable to parse announce-lead-minutes pref, defaulting to 5: %s", err)
+		lead = 5
+	}
+	c.AnnounceLeadMinutes = lead
+
+	modepref := hal.GetPref("", "", c.RoomId, "google_calendar", "autoreply-mode", string(ModeOncePerEvent))
+	c.AutoreplyMode = AutoreplyMode(modepref.Value)
+
+	cooldownpref := hal.GetPref("", "", c.RoomId, "google_calendar", "autoreply-cooldown", DefaultAutoreplyCooldown.String())
+	cooldown, err := time.ParseDuration(cooldownpref.Value)
+	if err != nil {
+		log.Printf("unable to parse autoreply-cooldown pref, defaulting to %s: %s", DefaultAutoreplyCooldown, err)
+		cooldown = DefaultAutoreplyCooldown
 	}
+	c.AutoreplyCooldown = cooldown
 
 	c.Autoreply = c.loadBoolPref("autoreply")
 	c.AnnounceStart = c.loadBoolPref("announce-start")
@@ -0,0 +1,97 @@
+package google_calendar
+
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"time"
+
+	"github.com/teambition/rrule-go"
+)
+
+// Occurrence is a single instance of a (possibly recurring) CalEvent,
+// carrying the instance's own Start/End alongside the event it came from.
+type Occurrence struct {
+	Start time.Time
+	End   time.Time
+	Event *CalEvent
+}
+
+// Occurrences expands e within [from, to), honoring RRule/RDates/ExDates. A
+// non-recurring event (empty RRule and no RDates) yields itself if it
+// overlaps the window.
+func (e *CalEvent) Occurrences(from, to time.Time) []Occurrence {
+	if e.RRule == "" && len(e.RDates) == 0 {
+		if e.End.After(from) && e.Start.Before(to) {
+			return []Occurrence{{Start: e.Start, End: e.End, Event: e}}
+		}
+		return nil
+	}
+
+	duration := e.End.Sub(e.Start)
+	// time.Time equality via map keys depends on identical Location pointers,
+	// so an EXDATE parsed in one zone wouldn't match an occurrence start
+	// computed in another even when they're the same instant. Normalize
+	// everything to UTC before keying the map.
+	excluded := make(map[time.Time]bool, len(e.ExDates))
+	for _, ex := range e.ExDates {
+		excluded[ex.UTC()] = true
+	}
+
+	var starts []time.Time
+
+	if e.RRule != "" {
+		rule, err := rrule.StrToRRule(e.RRule)
+		if err == nil {
+			rule.DTStart(e.Start)
+			starts = append(starts, rule.Between(from, to, true)...)
+		}
+	}
+
+	for _, rd := range e.RDates {
+		if !rd.Before(from) && rd.Before(to) {
+			starts = append(starts, rd)
+		}
+	}
+
+	out := make([]Occurrence, 0, len(starts))
+	for _, s := range starts {
+		// rule.Between is inclusive of to; keep the window half-open.
+		if excluded[s.UTC()] || !s.Before(to) {
+			continue
+		}
+		out = append(out, Occurrence{Start: s, End: s.Add(duration), Event: e})
+	}
+
+	return out
+}
+
+// expandOccurrences flattens events into a list of CalEvents bounded by
+// [window.Start, window.End), expanding any RRule/RDates into one CalEvent per instance so
+// handleEvt's autoreply/announce logic doesn't need to know about recurrence.
+func expandOccurrences(events []CalEvent, window Window) []CalEvent {
+	out := make([]CalEvent, 0, len(events))
+
+	for i := range events {
+		e := events[i]
+		for _, occ := range e.Occurrences(window.Start, window.End) {
+			instance := e
+			instance.Start = occ.Start
+			instance.End = occ.End
+			out = append(out, instance)
+		}
+	}
+
+	return out
+}
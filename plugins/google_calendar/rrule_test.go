@@ -0,0 +1,113 @@
+package google_calendar
+
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOccurrencesExpandsWeeklyRRule(t *testing.T) {
+	start := time.Date(2026, 7, 6, 9, 0, 0, 0, time.UTC) // a Monday
+	e := CalEvent{
+		Id:    "standup",
+		Name:  "Standup",
+		Start: start,
+		End:   start.Add(30 * time.Minute),
+		RRule: "FREQ=WEEKLY;COUNT=4",
+	}
+
+	from := time.Date(2026, 7, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2026, 8, 1, 0, 0, 0, 0, time.UTC)
+
+	occs := e.Occurrences(from, to)
+	if len(occs) != 4 {
+		t.Fatalf("expected 4 weekly occurrences, got %d", len(occs))
+	}
+	for i, occ := range occs {
+		want := start.AddDate(0, 0, 7*i)
+		if !occ.Start.Equal(want) {
+			t.Fatalf("occurrence %d: expected start %s, got %s", i, want, occ.Start)
+		}
+		if occ.End.Sub(occ.Start) != 30*time.Minute {
+			t.Fatalf("occurrence %d: expected 30m duration, got %s", i, occ.End.Sub(occ.Start))
+		}
+	}
+}
+
+func TestOccurrencesIncludesRDate(t *testing.T) {
+	start := time.Date(2026, 7, 6, 9, 0, 0, 0, time.UTC)
+	extra := time.Date(2026, 7, 15, 14, 0, 0, 0, time.UTC)
+	e := CalEvent{
+		Start:  start,
+		End:    start.Add(time.Hour),
+		RDates: []time.Time{extra},
+	}
+
+	occs := e.Occurrences(time.Date(2026, 7, 1, 0, 0, 0, 0, time.UTC), time.Date(2026, 8, 1, 0, 0, 0, 0, time.UTC))
+	if len(occs) != 1 {
+		t.Fatalf("expected 1 occurrence, got %d", len(occs))
+	}
+	if !occs[0].Start.Equal(extra) {
+		t.Fatalf("expected occurrence at %s, got %s", extra, occs[0].Start)
+	}
+}
+
+func TestOccurrencesExcludesExDate(t *testing.T) {
+	start := time.Date(2026, 7, 6, 9, 0, 0, 0, time.UTC)
+	loc, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		t.Skipf("tzdata not available: %s", err)
+	}
+
+	// the third instance, 2026-07-20, expressed in a different zone than the
+	// RRule was expanded in -- Occurrences must normalize to UTC before
+	// comparing so this still matches and gets excluded.
+	excluded := time.Date(2026, 7, 20, 9, 0, 0, 0, time.UTC).In(loc)
+
+	e := CalEvent{
+		Start:   start,
+		End:     start.Add(30 * time.Minute),
+		RRule:   "FREQ=WEEKLY;COUNT=4",
+		ExDates: []time.Time{excluded},
+	}
+
+	occs := e.Occurrences(time.Date(2026, 7, 1, 0, 0, 0, 0, time.UTC), time.Date(2026, 8, 1, 0, 0, 0, 0, time.UTC))
+	if len(occs) != 3 {
+		t.Fatalf("expected 3 occurrences after excluding one of 4, got %d", len(occs))
+	}
+	for _, occ := range occs {
+		if occ.Start.Equal(excluded) {
+			t.Fatalf("expected %s to be excluded, found it in the results", excluded)
+		}
+	}
+}
+
+func TestExpandOccurrencesBoundsToWindow(t *testing.T) {
+	start := time.Date(2026, 7, 6, 9, 0, 0, 0, time.UTC)
+	e := CalEvent{
+		Id:    "standup",
+		Start: start,
+		End:   start.Add(30 * time.Minute),
+		RRule: "FREQ=WEEKLY;COUNT=4",
+	}
+
+	window := Window{Start: time.Date(2026, 7, 13, 0, 0, 0, 0, time.UTC), End: time.Date(2026, 7, 20, 23, 59, 0, 0, time.UTC)}
+
+	out := expandOccurrences([]CalEvent{e}, window)
+	if len(out) != 2 {
+		t.Fatalf("expected 2 instances within the narrowed window, got %d", len(out))
+	}
+}
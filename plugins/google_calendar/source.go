@@ -0,0 +1,50 @@
+package google_calendar
+
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Window bounds a calendar query to the events starting or ending between
+// Start and End.
+type Window struct {
+	Start time.Time
+	End   time.Time
+}
+
+// CalendarSource is implemented by anything that can produce CalEvents for a
+// given window, decoupling the plugin's cache/autoreply/announce logic from
+// any one calendar provider.
+type CalendarSource interface {
+	Events(ctx context.Context, window Window) ([]CalEvent, error)
+}
+
+// sourceFor builds the CalendarSource configured for this room, chosen via
+// the "backend" pref: "google" (default), "caldav", or "ics-url".
+func sourceFor(c *Config) (CalendarSource, error) {
+	switch c.Backend {
+	case "", "google":
+		return &GoogleSource{RoomId: c.RoomId, UserId: c.CalendarUser, CalendarId: c.CalendarId}, nil
+	case "caldav":
+		return &CalDAVSource{URL: c.CalendarURL}, nil
+	case "ics-url":
+		return &ICSSource{URL: c.CalendarURL}, nil
+	default:
+		return nil, fmt.Errorf("unknown google_calendar backend %q", c.Backend)
+	}
+}
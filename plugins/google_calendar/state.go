@@ -0,0 +1,177 @@
+package google_calendar
+
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/netflix/hal-9001/hal"
+)
+
+/* ReplyState replaces the old "don't autoreply more than once an hour"
+ * hack with per-(room, event instance) bookkeeping, so a chatty room doesn't
+ * get spammed but a new user asking a question still gets a heads-up, and a
+ * restart doesn't re-announce an event that already started or ended.
+ *
+ * autoreply-mode: how autoreply squelching works.
+ *   once-per-event (default): reply the first time, then stay quiet for the
+ *     rest of that event instance.
+ *   once-per-user: reply once per event instance per distinct user.
+ *   interval: reply at most once per autoreply-cooldown, regardless of user.
+ * !prefs set --room <roomid> --plugin google_calendar --key autoreply-mode --value once-per-user
+ *
+ * autoreply-cooldown: only used by autoreply-mode=interval, a duration
+ * string like "30m". Defaults to 1h.
+ * !prefs set --room <roomid> --plugin google_calendar --key autoreply-cooldown --value 30m
+ */
+
+type AutoreplyMode string
+
+const (
+	ModeOncePerEvent AutoreplyMode = "once-per-event"
+	ModeOncePerUser  AutoreplyMode = "once-per-user"
+	ModeInterval     AutoreplyMode = "interval"
+)
+
+const DefaultAutoreplyCooldown = 1 * time.Hour
+
+// ReplyState tracks what's already been communicated about a single event
+// instance (keyed by room + event) so autoreply and announce-start/end don't
+// repeat themselves. It's shared between the per-message handleEvt path and
+// the announceLoop goroutine, so all access goes through mut.
+type ReplyState struct {
+	mut            sync.Mutex
+	RoomId         string
+	EventId        string
+	AnnouncedStart bool
+	AnnouncedEnd   bool
+	AutoreplySent  bool
+	RepliedUsers   map[string]bool
+	LastReply      time.Time
+}
+
+var stateCache map[string]*ReplyState
+var stateMut sync.Mutex
+
+func init() {
+	stateCache = make(map[string]*ReplyState)
+}
+
+// eventKey identifies a single instance of a (possibly recurring) event
+// within a room, stable across cache refreshes and restarts.
+func eventKey(e CalEvent) string {
+	id := e.Id
+	if id == "" {
+		id = e.Name
+	}
+	return id + "@" + e.Start.UTC().Format(time.RFC3339)
+}
+
+// loadReplyState returns the cached ReplyState for roomId/key, loading it
+// from hal.SetPref-backed storage on first use.
+func loadReplyState(roomId, key string) *ReplyState {
+	stateMut.Lock()
+	defer stateMut.Unlock()
+
+	cacheKey := roomId + "\x00" + key
+	if s, ok := stateCache[cacheKey]; ok {
+		return s
+	}
+
+	s := &ReplyState{RoomId: roomId, EventId: key}
+
+	pref := hal.GetPref("", "", roomId, "google_calendar", "state."+key, "")
+	if pref.Success && pref.Value != "" {
+		if err := json.Unmarshal([]byte(pref.Value), s); err != nil {
+			log.Printf("google_calendar: corrupt reply state for room %q event %q: %s", roomId, key, err)
+		}
+	}
+
+	stateCache[cacheKey] = s
+	return s
+}
+
+// Save persists s so a restart picks up where it left off.
+func (s *ReplyState) Save() {
+	s.mut.Lock()
+	b, err := json.Marshal(s)
+	s.mut.Unlock()
+
+	if err != nil {
+		log.Printf("google_calendar: unable to serialize reply state for room %q event %q: %s", s.RoomId, s.EventId, err)
+		return
+	}
+
+	hal.SetPref("", "", s.RoomId, "google_calendar", "state."+s.EventId, string(b))
+}
+
+// ShouldAutoreply reports whether an autoreply should be sent to userId for
+// this event instance under mode, and records that it was sent if so.
+func (s *ReplyState) ShouldAutoreply(mode AutoreplyMode, cooldown time.Duration, userId string, now time.Time) bool {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	switch mode {
+	case ModeOncePerUser:
+		if s.RepliedUsers == nil {
+			s.RepliedUsers = make(map[string]bool)
+		}
+		if s.RepliedUsers[userId] {
+			return false
+		}
+		s.RepliedUsers[userId] = true
+	case ModeInterval:
+		if !s.LastReply.IsZero() && now.Sub(s.LastReply) < cooldown {
+			return false
+		}
+	default: // ModeOncePerEvent
+		if s.AutoreplySent {
+			return false
+		}
+	}
+
+	s.AutoreplySent = true
+	s.LastReply = now
+	return true
+}
+
+// MarkAnnouncedStart reports whether the start of this event instance has
+// not yet been announced, atomically marking it announced if so.
+func (s *ReplyState) MarkAnnouncedStart() bool {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if s.AnnouncedStart {
+		return false
+	}
+	s.AnnouncedStart = true
+	return true
+}
+
+// MarkAnnouncedEnd reports whether the end of this event instance has not
+// yet been announced, atomically marking it announced if so.
+func (s *ReplyState) MarkAnnouncedEnd() bool {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if s.AnnouncedEnd {
+		return false
+	}
+	s.AnnouncedEnd = true
+	return true
+}
@@ -0,0 +1,108 @@
+package google_calendar
+
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"testing"
+	"time"
+)
+
+func TestShouldAutoreplyOncePerEvent(t *testing.T) {
+	now := time.Now()
+	s := &ReplyState{RoomId: "room1", EventId: "evt1"}
+
+	if !s.ShouldAutoreply(ModeOncePerEvent, 0, "alice", now) {
+		t.Fatal("expected the first autoreply for an event to fire")
+	}
+
+	if s.ShouldAutoreply(ModeOncePerEvent, 0, "bob", now) {
+		t.Fatal("expected once-per-event to squelch a second reply, even from a different user")
+	}
+}
+
+func TestShouldAutoreplyOncePerUser(t *testing.T) {
+	now := time.Now()
+	s := &ReplyState{RoomId: "room1", EventId: "evt1"}
+
+	if !s.ShouldAutoreply(ModeOncePerUser, 0, "alice", now) {
+		t.Fatal("expected alice's first message to get an autoreply")
+	}
+
+	if s.ShouldAutoreply(ModeOncePerUser, 0, "alice", now) {
+		t.Fatal("expected alice's second message to be squelched")
+	}
+
+	if !s.ShouldAutoreply(ModeOncePerUser, 0, "bob", now) {
+		t.Fatal("expected bob, a new user, to still get a heads-up")
+	}
+}
+
+func TestShouldAutoreplyInterval(t *testing.T) {
+	now := time.Now()
+	s := &ReplyState{RoomId: "room1", EventId: "evt1"}
+	cooldown := 10 * time.Minute
+
+	if !s.ShouldAutoreply(ModeInterval, cooldown, "alice", now) {
+		t.Fatal("expected the first message in an empty interval window to get an autoreply")
+	}
+
+	if s.ShouldAutoreply(ModeInterval, cooldown, "bob", now.Add(5*time.Minute)) {
+		t.Fatal("expected a message inside the cooldown window to be squelched")
+	}
+
+	if !s.ShouldAutoreply(ModeInterval, cooldown, "carol", now.Add(11*time.Minute)) {
+		t.Fatal("expected a message after the cooldown window to get an autoreply")
+	}
+}
+
+// TestEventKeyOverlappingEvents covers the old "should overlapping events
+// mean multiple messages?" TODO: two events overlapping in time but
+// otherwise distinct must squelch independently of one another.
+func TestEventKeyOverlappingEvents(t *testing.T) {
+	now := time.Now()
+
+	standup := CalEvent{Id: "standup-1", Name: "Standup", Start: now, End: now.Add(30 * time.Minute)}
+	oncall := CalEvent{Id: "oncall-1", Name: "On-call handoff", Start: now, End: now.Add(time.Hour)}
+
+	if eventKey(standup) == eventKey(oncall) {
+		t.Fatalf("expected overlapping-but-distinct events to have different keys, got %q for both", eventKey(standup))
+	}
+
+	standupState := loadReplyState("room1", eventKey(standup))
+	oncallState := loadReplyState("room1", eventKey(oncall))
+
+	if !standupState.ShouldAutoreply(ModeOncePerEvent, 0, "alice", now) {
+		t.Fatal("expected the standup to get its own autoreply")
+	}
+
+	if !oncallState.ShouldAutoreply(ModeOncePerEvent, 0, "alice", now) {
+		t.Fatal("expected the overlapping on-call handoff to get its own autoreply, independent of the standup")
+	}
+
+	if oncallState.ShouldAutoreply(ModeOncePerEvent, 0, "alice", now) {
+		t.Fatal("expected a second message during the on-call handoff to be squelched")
+	}
+}
+
+// TestEventKeyRecurringInstances ensures two instances of the same recurring
+// event (same Id, different Start) get independent squelch state.
+func TestEventKeyRecurringInstances(t *testing.T) {
+	week1 := CalEvent{Id: "standup", Name: "Standup", Start: time.Date(2026, 7, 20, 9, 0, 0, 0, time.UTC)}
+	week2 := CalEvent{Id: "standup", Name: "Standup", Start: time.Date(2026, 7, 27, 9, 0, 0, 0, time.UTC)}
+
+	if eventKey(week1) == eventKey(week2) {
+		t.Fatal("expected different instances of a recurring event to have different keys")
+	}
+}
@@ -0,0 +1,306 @@
+package google_calendar
+
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/netflix/hal-9001/hal"
+	calendar "google.golang.org/api/calendar/v3"
+)
+
+/* Background refresh, push notifications, and start/end announcements.
+ *
+ * webhook-base-url: the publicly reachable base URL Hal is exposed on,
+ * needed to register a Google Calendar push channel. Without it the plugin
+ * falls back to polling only (see refreshInterval below).
+ * !prefs set --room <roomid> --plugin google_calendar --key webhook-base-url --value https://hal.example.com
+ *
+ * announce-lead-minutes: how many minutes before an event starts the
+ * announce-start message fires; announce-end fires as the event ends, and
+ * the same number of minutes bounds how late after the end it may still be
+ * sent. Defaults to 5.
+ * !prefs set --room <roomid> --plugin google_calendar --key announce-lead-minutes --value 10
+ */
+
+const refreshInterval = 5 * time.Minute
+const watchRenewInterval = 20 * time.Hour
+const announceCheckInterval = 30 * time.Second
+const watchCallbackPath = "/google_calendar/watch"
+
+// registerWatchEndpoint wires the push-notification callback into hal's
+// router. Called once from Register, not per-room.
+func registerWatchEndpoint() {
+	hal.Router.HandleFunc(watchCallbackPath, handleWatchCallback)
+}
+
+// refreshLoop periodically pulls incremental changes from the calendar
+// backend and keeps the room's Google Calendar watch channel renewed before
+// it expires.
+func (c *Config) refreshLoop() {
+	c.registerWatch()
+
+	refresh := time.NewTicker(refreshInterval)
+	defer refresh.Stop()
+
+	renew := time.NewTicker(watchRenewInterval)
+	defer renew.Stop()
+
+	for {
+		select {
+		case <-refresh.C:
+			if err := c.applyIncrementalSync(time.Now()); err != nil {
+				log.Printf("google_calendar: refresh failed for room %q: %s", c.RoomId, err)
+			}
+		case <-renew.C:
+			c.registerWatch()
+		}
+	}
+}
+
+// announceLoop fires announce-start/announce-end messages at event
+// boundaries, with a pre-alert window controlled by announce-lead-minutes.
+func (c *Config) announceLoop() {
+	ticker := time.NewTicker(announceCheckInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		c.checkAnnouncements(time.Now())
+	}
+}
+
+func (c *Config) checkAnnouncements(now time.Time) {
+	c.mut.Lock()
+	events := c.CalEvents
+	announceStart := c.AnnounceStart
+	announceEnd := c.AnnounceEnd
+	lead := time.Duration(c.AnnounceLeadMinutes) * time.Minute
+	c.mut.Unlock()
+
+	for _, e := range events {
+		state := loadReplyState(c.RoomId, eventKey(e))
+
+		if announceStart && !now.Before(e.Start.Add(-lead)) && now.Before(e.Start) && state.MarkAnnouncedStart() {
+			hal.SendMessage(c.RoomId, fmt.Sprintf("Starting soon: %s", announceText(e)))
+			state.Save()
+		}
+
+		if announceEnd && !now.Before(e.End) && now.Before(e.End.Add(lead)) && state.MarkAnnouncedEnd() {
+			hal.SendMessage(c.RoomId, fmt.Sprintf("Event ending: %s", announceText(e)))
+			state.Save()
+		}
+	}
+}
+
+func announceText(e CalEvent) string {
+	if e.Description != "" {
+		return e.Description
+	}
+	return e.Name
+}
+
+// applyIncrementalSync refreshes c.rawEvents from the configured backend. For
+// the Google backend this uses a sync token so only changed events are
+// fetched; other backends don't support incremental sync so the full window
+// is refetched.
+func (c *Config) applyIncrementalSync(now time.Time) error {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	src, err := sourceFor(c)
+	if err != nil {
+		return err
+	}
+
+	window := Window{Start: now.Add(-1 * time.Hour), End: now.Add(24 * time.Hour)}
+
+	gsrc, ok := src.(*GoogleSource)
+	if !ok {
+		evts, err := src.Events(context.Background(), window)
+		if err != nil {
+			return err
+		}
+		c.rawEvents = evts
+		c.CalEvents = expandOccurrences(c.rawEvents, window)
+		c.calTs = now
+		return nil
+	}
+
+	changed, nextToken, err := gsrc.EventsSync(context.Background(), window, c.SyncToken)
+	if err != nil {
+		return err
+	}
+
+	c.mergeEvents(changed)
+	c.SyncToken = nextToken
+	c.CalEvents = expandOccurrences(c.rawEvents, window)
+	c.calTs = now
+
+	return nil
+}
+
+// mergeEvents upserts changed into c.rawEvents by Id, dropping any event
+// whose Cancelled flag is set (Google reports deletions this way during an
+// incremental sync).
+func (c *Config) mergeEvents(changed []CalEvent) {
+	byId := make(map[string]CalEvent, len(c.rawEvents))
+	for _, e := range c.rawEvents {
+		byId[e.Id] = e
+	}
+
+	for _, e := range changed {
+		if e.Cancelled {
+			delete(byId, e.Id)
+			continue
+		}
+		byId[e.Id] = e
+	}
+
+	out := make([]CalEvent, 0, len(byId))
+	for _, e := range byId {
+		out = append(out, e)
+	}
+	c.rawEvents = out
+}
+
+// registerWatch asks the Google Calendar API to push change notifications to
+// this Hal instance's webhook endpoint, so cache invalidation doesn't have to
+// wait for the next poll. A no-op for non-Google backends or rooms that
+// haven't configured webhook-base-url/authenticated yet.
+func (c *Config) registerWatch() {
+	c.mut.Lock()
+	backend, calendarId, userId, baseURL := c.Backend, c.CalendarId, c.CalendarUser, c.WebhookBaseURL
+	c.mut.Unlock()
+
+	if backend != "" && backend != "google" {
+		return
+	}
+	if baseURL == "" {
+		return
+	}
+
+	cc, err := NewCalClient(context.Background(), c.RoomId, userId)
+	if err != nil || cc.Token == nil {
+		return
+	}
+
+	svc, err := calendar.New(cc.HTTPClient().Client)
+	if err != nil {
+		log.Printf("google_calendar: unable to create client to register watch for room %q: %s", c.RoomId, err)
+		return
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		log.Printf("google_calendar: unable to generate watch channel token: %s", err)
+		return
+	}
+
+	channel := &calendar.Channel{
+		Id:      fmt.Sprintf("hal-%s-%d", c.RoomId, time.Now().Unix()),
+		Type:    "web_hook",
+		Address: baseURL + watchCallbackPath,
+		Token:   token,
+	}
+
+	resp, err := svc.Events.Watch(calendarId, channel).Do()
+	if err != nil {
+		log.Printf("google_calendar: unable to register watch channel for room %q: %s", c.RoomId, err)
+		return
+	}
+
+	c.mut.Lock()
+	c.WatchChannelId = resp.Id
+	c.WatchResourceId = resp.ResourceId
+	c.WatchToken = token
+	c.mut.Unlock()
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// handleWatchCallback is the HTTP endpoint Google POSTs change notifications
+// to. It verifies X-Goog-Channel-Token against the channel we registered for
+// the matching room and, if it matches, re-syncs just that room's calendar.
+func handleWatchCallback(w http.ResponseWriter, r *http.Request) {
+	channelId := r.Header.Get("X-Goog-Channel-Id")
+	token := r.Header.Get("X-Goog-Channel-Token")
+	resourceState := r.Header.Get("X-Goog-Resource-State")
+
+	c := configForChannel(channelId)
+	if c == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if token != c.watchToken() {
+		log.Printf("google_calendar: watch callback for room %q had a mismatched channel token, ignoring", c.RoomId)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	// the "sync" state is Google's initial handshake when the channel is
+	// created; there's nothing to re-sync yet.
+	if resourceState != "sync" {
+		if err := c.applyIncrementalSync(time.Now()); err != nil {
+			log.Printf("google_calendar: push-triggered sync failed for room %q: %s", c.RoomId, err)
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func configForChannel(channelId string) *Config {
+	topMut.Lock()
+	candidates := make([]*Config, 0, len(configCache))
+	for _, c := range configCache {
+		candidates = append(candidates, c)
+	}
+	topMut.Unlock()
+
+	for _, c := range candidates {
+		if c.watchChannelId() == channelId {
+			return c
+		}
+	}
+
+	return nil
+}
+
+// watchChannelId and watchToken read WatchChannelId/WatchToken under c.mut,
+// since they're written from the refresh goroutine's registerWatch and read
+// from every incoming webhook POST.
+func (c *Config) watchChannelId() string {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	return c.WatchChannelId
+}
+
+func (c *Config) watchToken() string {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	return c.WatchToken
+}